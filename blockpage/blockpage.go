@@ -0,0 +1,45 @@
+// Package blockpage renders the HTML page shown to a user in place of a
+// blocked request: the URL that was blocked, the rule that matched, the
+// reason (blockmode, hours, clocking), and a countdown when the block is
+// time-based. The template is loaded from disk when a template directory
+// is configured, falling back to a compiled-in copy otherwise.
+package blockpage
+
+import (
+	"embed"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+//go:embed templates/block.html
+var defaultFS embed.FS
+
+var defaultTemplate = template.Must(template.ParseFS(defaultFS, "templates/block.html"))
+
+// Page carries the values substituted into the block page template.
+type Page struct {
+	URL         string
+	Rule        string
+	Reason      string
+	MinutesLeft int
+}
+
+// Render writes the block page for p to w. When templateDir is non-empty
+// and contains a "block.html", that template is used instead of the
+// compiled-in default.
+func Render(w io.Writer, templateDir string, p Page) error {
+	if templateDir != "" {
+		path := filepath.Join(templateDir, "block.html")
+		if _, err := os.Stat(path); err == nil {
+			t, err := template.ParseFiles(path)
+			if err != nil {
+				return err
+			}
+			return t.Execute(w, p)
+		}
+	}
+
+	return defaultTemplate.Execute(w, p)
+}
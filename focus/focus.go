@@ -0,0 +1,55 @@
+// Package focus abstracts "am I currently in focus time?" behind a
+// FocusSource interface so the redirector can block on org-mode clocking,
+// a Pomodoro timer, an iCalendar feed, or Taskwarrior's active task
+// interchangeably, and combine any number of them.
+package focus
+
+import "time"
+
+// FocusSource reports whether its underlying signal currently considers
+// the user focused, since when that state began, and a short
+// human-readable detail suitable for display on the web UI.
+type FocusSource interface {
+	IsFocused() (bool, time.Time, string)
+	Name() string
+}
+
+// SourceState is the point-in-time state of one FocusSource, as surfaced
+// on the web UI.
+type SourceState struct {
+	Name    string    `json:"name"`
+	Focused bool      `json:"focused"`
+	Since   time.Time `json:"since"`
+	Detail  string    `json:"detail"`
+}
+
+// Aggregate combines any number of FocusSources: the aggregate is focused
+// when any one of them is.
+type Aggregate struct {
+	Sources []FocusSource
+}
+
+// IsFocused reports whether any source currently reports focus time.
+func (a *Aggregate) IsFocused() bool {
+	for _, s := range a.Sources {
+		if focused, _, _ := s.IsFocused(); focused {
+			return true
+		}
+	}
+	return false
+}
+
+// States returns the current state of every source, for display.
+func (a *Aggregate) States() []SourceState {
+	states := make([]SourceState, 0, len(a.Sources))
+	for _, s := range a.Sources {
+		focused, since, detail := s.IsFocused()
+		states = append(states, SourceState{
+			Name:    s.Name(),
+			Focused: focused,
+			Since:   since,
+			Detail:  detail,
+		})
+	}
+	return states
+}
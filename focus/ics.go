@@ -0,0 +1,149 @@
+package focus
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// icsTimeLayouts are the DTSTART/DTEND encodings seen in the wild: with
+// and without a trailing "Z", and a date-only form without the time
+// component.
+var icsTimeLayouts = []string{
+	"20060102T150405Z",
+	"20060102T150405",
+	"20060102",
+}
+
+// ICSSource treats any currently-active VEVENT tagged with Category as
+// focus time, polling the file on an interval since it's edited on disk
+// rather than pushed.
+type ICSSource struct {
+	Path     string
+	Category string
+
+	mu      sync.RWMutex
+	focused bool
+	since   time.Time
+	detail  string
+}
+
+// NewICSSource starts polling the .ics file at path every interval,
+// treating VEVENTs whose CATEGORIES include category as focus time.
+func NewICSSource(path, category string, interval time.Duration) *ICSSource {
+	s := &ICSSource{Path: path, Category: category}
+	go func() {
+		for {
+			s.refresh()
+			time.Sleep(interval)
+		}
+	}()
+	return s
+}
+
+func (s *ICSSource) refresh() {
+	focused, since, detail := s.evaluate()
+
+	s.mu.Lock()
+	s.focused = focused
+	s.since = since
+	s.detail = detail
+	s.mu.Unlock()
+}
+
+// evaluate reads and parses Path fresh and reports whether a VEVENT
+// tagged Category is active right now.
+func (s *ICSSource) evaluate() (bool, time.Time, string) {
+	events, err := parseICS(s.Path)
+	if err != nil {
+		return false, time.Time{}, "ics: " + err.Error()
+	}
+
+	now := time.Now()
+	for _, ev := range events {
+		if !strings.Contains(ev.categories, s.Category) {
+			continue
+		}
+		if now.Before(ev.start) || now.After(ev.end) {
+			continue
+		}
+		return true, ev.start, "ics: " + ev.summary
+	}
+
+	return false, time.Time{}, "ics: no matching event"
+}
+
+// IsFocused reports the most recently polled state.
+func (s *ICSSource) IsFocused() (bool, time.Time, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.focused, s.since, s.detail
+}
+
+// Name identifies this source on the web UI.
+func (s *ICSSource) Name() string {
+	return "ics"
+}
+
+type icsEvent struct {
+	start      time.Time
+	end        time.Time
+	summary    string
+	categories string
+}
+
+func parseICS(path string) ([]icsEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []icsEvent
+	var cur *icsEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "BEGIN:VEVENT":
+			cur = &icsEvent{}
+		case line == "END:VEVENT":
+			if cur != nil {
+				events = append(events, *cur)
+			}
+			cur = nil
+		case cur == nil:
+			continue
+		case strings.HasPrefix(line, "DTSTART"):
+			cur.start = parseICSTime(valueOf(line))
+		case strings.HasPrefix(line, "DTEND"):
+			cur.end = parseICSTime(valueOf(line))
+		case strings.HasPrefix(line, "SUMMARY:"):
+			cur.summary = valueOf(line)
+		case strings.HasPrefix(line, "CATEGORIES:"):
+			cur.categories = valueOf(line)
+		}
+	}
+
+	return events, scanner.Err()
+}
+
+// valueOf returns the part of an "ICS-PROP;PARAM=x:value" line after the
+// first colon.
+func valueOf(line string) string {
+	if i := strings.Index(line, ":"); i >= 0 {
+		return line[i+1:]
+	}
+	return ""
+}
+
+func parseICSTime(value string) time.Time {
+	for _, layout := range icsTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
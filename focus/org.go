@@ -0,0 +1,103 @@
+package focus
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// OrgSource walks an org-mode directory looking for an open CLOCK entry
+// (a "CLOCK:" line with no "=>" duration yet), polling on an interval
+// since org files are edited on disk rather than pushed.
+type OrgSource struct {
+	Dir string
+
+	mu      sync.RWMutex
+	focused bool
+	since   time.Time
+}
+
+// NewOrgSource starts polling dir every interval and returns the source.
+func NewOrgSource(dir string, interval time.Duration) *OrgSource {
+	s := &OrgSource{Dir: dir}
+	go func() {
+		for {
+			s.refresh()
+			time.Sleep(interval)
+		}
+	}()
+	return s
+}
+
+func (s *OrgSource) refresh() {
+	focused := false
+	filepath.Walk(s.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if isClocking(path) {
+			focused = true
+		}
+		return nil
+	})
+
+	s.mu.Lock()
+	if focused && !s.focused {
+		s.since = time.Now()
+	}
+	s.focused = focused
+	s.mu.Unlock()
+}
+
+func isClocking(path string) bool {
+	isOrg, _ := regexp.MatchString("\\.org", path)
+	if !isOrg {
+		return false
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	defer f.Close()
+
+	reader := bufio.NewReaderSize(f, 16*1024)
+	line, isPrefix, err := reader.ReadLine()
+	for err == nil && !isPrefix {
+		s := string(line)
+
+		isClock, _ := regexp.MatchString(".*CLOCK:.*", s)
+		isEnded, _ := regexp.MatchString(".*CLOCK:.*--.*=>.*", s)
+
+		if isClock && !isEnded {
+			return true
+		}
+
+		line, isPrefix, err = reader.ReadLine()
+	}
+	if isPrefix {
+		log.Println("buffer size to small")
+	} else if err != io.EOF {
+		log.Println(err)
+	}
+
+	return false
+}
+
+// IsFocused reports whether any org file under Dir has an open clock.
+func (s *OrgSource) IsFocused() (bool, time.Time, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.focused, s.since, "org-mode clocking"
+}
+
+// Name identifies this source on the web UI.
+func (s *OrgSource) Name() string {
+	return "org"
+}
@@ -0,0 +1,125 @@
+package focus
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// PomodoroSource is a manually-started 25/5 work/break timer, controlled
+// via the small HTTP endpoint returned by Handler.
+type PomodoroSource struct {
+	Work  time.Duration
+	Break time.Duration
+
+	mu      sync.Mutex
+	running bool
+	onBreak bool
+	since   time.Time
+	timer   *time.Timer
+}
+
+// NewPomodoroSource returns a stopped timer using the classic 25/5 cycle.
+func NewPomodoroSource() *PomodoroSource {
+	return &PomodoroSource{Work: 25 * time.Minute, Break: 5 * time.Minute}
+}
+
+// Start begins a work interval, resetting any cycle already in progress.
+func (p *PomodoroSource) Start() {
+	p.mu.Lock()
+	p.running = true
+	p.onBreak = false
+	p.since = time.Now()
+	p.mu.Unlock()
+
+	p.schedule(p.Work)
+}
+
+// Stop ends the timer; IsFocused reports false until Start is called again.
+func (p *PomodoroSource) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running = false
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+}
+
+// Skip ends the current work or break interval early and starts the next one.
+func (p *PomodoroSource) Skip() {
+	p.mu.Lock()
+	running := p.running
+	p.mu.Unlock()
+	if running {
+		p.transition()
+	}
+}
+
+func (p *PomodoroSource) schedule(d time.Duration) {
+	p.mu.Lock()
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.timer = time.AfterFunc(d, p.transition)
+	p.mu.Unlock()
+}
+
+func (p *PomodoroSource) transition() {
+	p.mu.Lock()
+	if !p.running {
+		p.mu.Unlock()
+		return
+	}
+	p.onBreak = !p.onBreak
+	p.since = time.Now()
+	next := p.Work
+	if p.onBreak {
+		next = p.Break
+	}
+	p.mu.Unlock()
+
+	p.schedule(next)
+}
+
+// IsFocused reports true while a work interval is running, false during
+// a break or when the timer is stopped.
+func (p *PomodoroSource) IsFocused() (bool, time.Time, string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	detail := "pomodoro idle"
+	if p.running {
+		if p.onBreak {
+			detail = "pomodoro break"
+		} else {
+			detail = "pomodoro work"
+		}
+	}
+
+	return p.running && !p.onBreak, p.since, detail
+}
+
+// Name identifies this source on the web UI.
+func (p *PomodoroSource) Name() string {
+	return "pomodoro"
+}
+
+// Handler returns the start/stop/skip endpoint to mount on the web server,
+// e.g. at "/focus/pomodoro/".
+func (p *PomodoroSource) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/focus/pomodoro/start", func(w http.ResponseWriter, req *http.Request) {
+		p.Start()
+		fmt.Fprintln(w, "started")
+	})
+	mux.HandleFunc("/focus/pomodoro/stop", func(w http.ResponseWriter, req *http.Request) {
+		p.Stop()
+		fmt.Fprintln(w, "stopped")
+	})
+	mux.HandleFunc("/focus/pomodoro/skip", func(w http.ResponseWriter, req *http.Request) {
+		p.Skip()
+		fmt.Fprintln(w, "skipped")
+	})
+	return mux
+}
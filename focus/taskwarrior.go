@@ -0,0 +1,76 @@
+package focus
+
+import (
+	"encoding/json"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// TaskwarriorSource treats any task tagged +ACTIVE (i.e. currently being
+// tracked with "task start") as focus time, polling the CLI on an
+// interval rather than forking it on every IsFocused call.
+type TaskwarriorSource struct {
+	mu      sync.RWMutex
+	focused bool
+	since   time.Time
+	detail  string
+}
+
+// NewTaskwarriorSource starts polling "task +ACTIVE export" every
+// interval and returns the source.
+func NewTaskwarriorSource(interval time.Duration) *TaskwarriorSource {
+	s := &TaskwarriorSource{}
+	go func() {
+		for {
+			s.refresh()
+			time.Sleep(interval)
+		}
+	}()
+	return s
+}
+
+func (s *TaskwarriorSource) refresh() {
+	focused, since, detail := s.evaluate()
+
+	s.mu.Lock()
+	if focused && s.focused {
+		since = s.since
+	}
+	s.focused = focused
+	s.since = since
+	s.detail = detail
+	s.mu.Unlock()
+}
+
+// evaluate runs "task +ACTIVE export" fresh and reports whether any task
+// is active right now.
+func (s *TaskwarriorSource) evaluate() (bool, time.Time, string) {
+	out, err := exec.Command("task", "+ACTIVE", "export").Output()
+	if err != nil {
+		return false, time.Time{}, "taskwarrior: " + err.Error()
+	}
+
+	var tasks []map[string]interface{}
+	if err := json.Unmarshal(out, &tasks); err != nil {
+		return false, time.Time{}, "taskwarrior: " + err.Error()
+	}
+	if len(tasks) == 0 {
+		return false, time.Time{}, "taskwarrior: no active task"
+	}
+
+	description, _ := tasks[0]["description"].(string)
+	return true, time.Now(), "taskwarrior: " + description
+}
+
+// IsFocused reports the most recently polled state.
+func (s *TaskwarriorSource) IsFocused() (bool, time.Time, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.focused, s.since, s.detail
+}
+
+// Name identifies this source on the web UI.
+func (s *TaskwarriorSource) Name() string {
+	return "taskwarrior"
+}
@@ -1,31 +1,73 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/elazarl/goproxy"
-	"io"
+	"github.com/tumurzakov/redirector/blockpage"
+	"github.com/tumurzakov/redirector/focus"
+	"github.com/tumurzakov/redirector/ruleset"
+	"github.com/tumurzakov/redirector/stats"
+	"github.com/tumurzakov/redirector/upstream"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/net/proxy"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
-	"regexp"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
 type Redirector struct {
-	Proxy     *goproxy.ProxyHttpServer
-	Hosts     []string
-	Clocking  bool
-	ProxyAddr string
-	WebAddr   string
-	Hours     []string
-	OrgDir    string
-	Blacklist string
-	Blockmode bool
+	Proxy         *goproxy.ProxyHttpServer
+	Rules         *ruleset.RuleSet
+	Focus         *focus.Aggregate
+	ProxyAddr     string
+	WebAddr       string
+	Hours         []string
+	OrgDir        string
+	Blacklist     string
+	Blockmode     bool
+	RulesURL      string
+	RulesRefresh  time.Duration
+	TemplateDir   string
+	Pomodoro      bool
+	ICSFile       string
+	ICSCategory   string
+	Taskwarrior   bool
+	LogFile       string
+	LogFormat     string
+	StatsDB       string
+	StatsInterval time.Duration
+	HistorySize   int
+	Upstreams     string
+	CheckURL      string
+	CheckInterval time.Duration
+
+	AccessLog *stats.AccessLogger
+	Counters  *stats.Counters
+	History   *stats.History
+	Pool      *upstream.Pool
+	db        *bolt.DB
+}
+
+// Decision is the result of evaluating a host against the ruleset and the
+// current blocking state: whether it's denied, which rule matched, why
+// (blockmode, hours, clocking), and for hour-based blocks, how many
+// minutes remain until it lifts.
+type Decision struct {
+	Denied      bool
+	Rule        string
+	Reason      string
+	MinutesLeft int
 }
 
 func main() {
@@ -38,9 +80,24 @@ func main() {
 
 	flag.StringVar(&r.ProxyAddr, "proxy", ":8080", "Proxy listen address")
 	flag.StringVar(&r.WebAddr, "web", ":8081", "Proxy listen address")
-	flag.StringVar(&r.Blacklist, "blacklist", "blacklist", "File that contains a list of blocking urls(regexp)")
+	flag.StringVar(&r.Blacklist, "blacklist", "blacklist", "File with AutoProxy/GFWList-style blacklist rules (||domain, |prefix, /regex/, @@whitelist, ! comment)")
 	flag.StringVar(&r.OrgDir, "orgdir", "", "Orgmode directory to parse clocking instructions")
 	flag.BoolVar(&r.Blockmode, "blockmode", false, "Default blocking")
+	flag.StringVar(&r.RulesURL, "rules-url", "", "Remote URL to periodically refresh the blacklist from (base64-decoded if needed)")
+	flag.DurationVar(&r.RulesRefresh, "rules-refresh", 1*time.Hour, "Interval to re-fetch -rules-url")
+	flag.StringVar(&r.TemplateDir, "templatedir", "", "Directory to load block.html from; falls back to the compiled-in template")
+	flag.BoolVar(&r.Pomodoro, "pomodoro", false, "Enable a 25/5 Pomodoro focus source, controlled via /focus/pomodoro/{start,stop,skip} on the web port")
+	flag.StringVar(&r.ICSFile, "icsfile", "", "iCalendar file to watch for active VEVENTs as a focus source")
+	flag.StringVar(&r.ICSCategory, "icscategory", "focus", "CATEGORIES value that marks a VEVENT as focus time")
+	flag.BoolVar(&r.Taskwarrior, "taskwarrior", false, "Enable a focus source backed by \"task +ACTIVE export\"")
+	flag.StringVar(&r.LogFile, "logfile", "", "Access log path; rotated by reopening on SIGHUP")
+	flag.StringVar(&r.LogFormat, "logformat", "combined", "Access log format: combined, json, or tsv")
+	flag.StringVar(&r.StatsDB, "statsdb", "", "bbolt file to persist per-host stats to")
+	flag.DurationVar(&r.StatsInterval, "statsinterval", 1*time.Minute, "Interval to persist stats to -statsdb")
+	flag.IntVar(&r.HistorySize, "historysize", 10000, "Number of recent requests to keep in memory for /api/history and /api/blocked")
+	flag.StringVar(&r.Upstreams, "upstreams", "", "File of \"name url\" parent proxies, routed to by \"route:name\" blacklist rules")
+	flag.StringVar(&r.CheckURL, "checkurl", "http://www.gstatic.com/generate_204", "URL fetched through each upstream to probe its health")
+	flag.DurationVar(&r.CheckInterval, "checkinterval", 30*time.Second, "Interval between upstream health checks")
 
 	var hours string
 	flag.StringVar(&hours, "hours", "", "Working hours, example: 8-11,13-17")
@@ -52,9 +109,13 @@ func main() {
 }
 
 func (r *Redirector) Init() error {
-	if r.OrgDir != "" {
-		r.InitOrgReader()
-	}
+	r.InitFocusSources()
+	r.InitStats()
+
+	// InitHosts must run before the web server starts listening: its
+	// /api/rules/reload handler calls r.Rules.Load directly, and a reload
+	// hitting a nil r.Rules would panic.
+	r.InitHosts()
 
 	go r.InitWebServer()
 
@@ -63,7 +124,115 @@ func (r *Redirector) Init() error {
 	return nil
 }
 
+// InitStats wires up the access logger and per-host counters: -logfile
+// turns on request logging (reopened on SIGHUP for rotation), -statsdb
+// turns on persistence of the in-memory counters.
+func (r *Redirector) InitStats() {
+	r.Counters = stats.NewCounters()
+	r.History = stats.NewHistory(r.HistorySize)
+
+	if r.LogFile != "" {
+		l, err := stats.NewAccessLogger(r.LogFile, r.LogFormat)
+		if err != nil {
+			log.Println(err)
+		} else {
+			r.AccessLog = l
+
+			sighup := make(chan os.Signal, 1)
+			signal.Notify(sighup, syscall.SIGHUP)
+			go func() {
+				for range sighup {
+					if err := r.AccessLog.Reopen(); err != nil {
+						log.Println(err)
+					}
+				}
+			}()
+		}
+	}
+
+	if r.StatsDB != "" {
+		db, err := bolt.Open(r.StatsDB, 0600, nil)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		r.db = db
+
+		if err := r.Counters.LoadFrom(db); err != nil {
+			log.Println(err)
+		}
+		go r.Counters.PersistLoop(db, r.StatsInterval, nil)
+	}
+}
+
+// recordRequest logs and counts one proxied request.
+func (r *Redirector) recordRequest(host, method, url string, status int, size int64, d Decision) {
+	e := stats.Entry{
+		Time:    time.Now(),
+		Host:    host,
+		Method:  method,
+		URL:     url,
+		Status:  status,
+		Bytes:   size,
+		Allowed: !d.Denied,
+		Rule:    d.Rule,
+	}
+
+	if r.AccessLog != nil {
+		if err := r.AccessLog.Log(e); err != nil {
+			log.Println(err)
+		}
+	}
+	r.Counters.Record(host, e.Allowed, size)
+	r.History.Add(e)
+}
+
+// InitFocusSources builds the aggregate of FocusSources selected via
+// config; IsDenied blocks when any of them reports focus time.
+func (r *Redirector) InitFocusSources() {
+	r.Focus = &focus.Aggregate{}
+
+	if r.OrgDir != "" {
+		r.Focus.Sources = append(r.Focus.Sources, focus.NewOrgSource(r.OrgDir, 10*time.Second))
+	}
+	if r.Pomodoro {
+		r.Focus.Sources = append(r.Focus.Sources, focus.NewPomodoroSource())
+	}
+	if r.ICSFile != "" {
+		r.Focus.Sources = append(r.Focus.Sources, focus.NewICSSource(r.ICSFile, r.ICSCategory, 10*time.Second))
+	}
+	if r.Taskwarrior {
+		r.Focus.Sources = append(r.Focus.Sources, focus.NewTaskwarriorSource(10*time.Second))
+	}
+}
+
 func (r *Redirector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if strings.HasPrefix(req.URL.Path, "/focus/pomodoro/") {
+		r.pomodoroHandler().ServeHTTP(w, req)
+		return
+	}
+	if req.URL.Path == "/focus" {
+		if err := json.NewEncoder(w).Encode(r.Focus.States()); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	switch req.URL.Path {
+	case "/api/stats":
+		stats.StatsHandler(r.Counters)(w, req)
+		return
+	case "/api/history":
+		stats.HistoryHandler(r.History)(w, req)
+		return
+	case "/api/blocked":
+		stats.BlockedHandler(r.History)(w, req)
+		return
+	case "/api/rules/reload":
+		stats.ReloadHandler(func() error { return r.Rules.Load(r.Blacklist) })(w, req)
+		return
+	}
+
 	if _, err := os.Stat(req.URL.Path[1:]); os.IsNotExist(err) {
 		http.ServeFile(w, req, "index.html")
 	} else {
@@ -71,6 +240,19 @@ func (r *Redirector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// pomodoroHandler returns the Pomodoro source's control endpoint, or a
+// handler reporting it's disabled when -pomodoro wasn't set.
+func (r *Redirector) pomodoroHandler() http.Handler {
+	for _, s := range r.Focus.Sources {
+		if p, ok := s.(*focus.PomodoroSource); ok {
+			return p.Handler()
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "pomodoro focus source not enabled (-pomodoro)", http.StatusNotFound)
+	})
+}
+
 func (r *Redirector) InitWebServer() error {
 	log.Fatalln(http.ListenAndServe(r.WebAddr, r))
 	return nil
@@ -80,82 +262,189 @@ func (r *Redirector) InitProxyServer() error {
 	r.Proxy = goproxy.NewProxyHttpServer()
 	r.Proxy.Verbose = true
 
-	r.InitHosts()
+	r.InitUpstreams()
 
-	for i := 0; i < len(r.Hosts); i++ {
-		r.Proxy.OnRequest(goproxy.ReqHostMatches(regexp.MustCompile("^.*" + r.Hosts[i] + "$"))).HandleConnect(goproxy.AlwaysMitm)
-	}
+	// Denied hosts are MITM'd rather than rejected outright, so the block
+	// page below is served as a real decrypted response inside the TLS
+	// tunnel (what RejectConnect writes goes to the raw pre-TLS CONNECT
+	// socket, which browsers don't render).
+	r.Proxy.OnRequest(goproxy.ReqConditionFunc(func(req *http.Request, ctx *goproxy.ProxyCtx) bool {
+		return r.IsDenied(req.URL.Host, req.URL.String()).Denied
+	})).HandleConnect(goproxy.AlwaysMitm)
 
 	r.Proxy.OnRequest().HandleConnectFunc(func(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
-		if r.IsDenied(ctx.Req.URL.Host) {
-			r.Redirect(ctx)
-		}
 		return goproxy.OkConnect, host
 	})
 
 	r.Proxy.OnRequest().DoFunc(
 		func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
-			if r.IsDenied(ctx.Req.URL.Host) {
-				r.Redirect(ctx)
+			d := r.IsDenied(ctx.Req.URL.Host, ctx.Req.URL.String())
+			ctx.UserData = d
+			if d.Denied {
+				return req, r.BlockResponse(req, d)
 			}
 
 			return req, nil
 		})
 
+	r.Proxy.OnResponse().DoFunc(
+		func(resp *http.Response, ctx *goproxy.ProxyCtx) *http.Response {
+			d, _ := ctx.UserData.(Decision)
+			status := 0
+			var size int64
+			if resp != nil {
+				status = resp.StatusCode
+				size = resp.ContentLength
+			}
+			r.recordRequest(ctx.Req.URL.Host, ctx.Req.Method, ctx.Req.URL.String(), status, size, d)
+			return resp
+		})
+
 	log.Fatalln(http.ListenAndServe(r.ProxyAddr, r.Proxy))
 
 	return nil
 }
 
 func (r *Redirector) InitHosts() error {
-	f, err := os.Open(r.Blacklist)
-	if err != nil {
+	r.Rules = ruleset.New()
+
+	if err := r.Rules.Load(r.Blacklist); err != nil {
 		log.Println(err)
 		return err
 	}
-	defer f.Close()
-	reader := bufio.NewReaderSize(f, 16*1024)
-	line, isPrefix, err := reader.ReadLine()
-	for err == nil && !isPrefix {
-		s := string(line)
-
-		r.Hosts = append(r.Hosts, s)
 
-		line, isPrefix, err = reader.ReadLine()
+	if r.RulesURL != "" {
+		if err := r.Rules.LoadRemote(r.RulesURL); err != nil {
+			log.Println(err)
+		}
+		r.Rules.Refresh(r.RulesURL, r.RulesRefresh)
 	}
-	if isPrefix {
-		log.Println("buffer size to small")
+
+	return nil
+}
+
+// InitUpstreams loads -upstreams, if set, starts health checking them, and
+// routes the proxy's outgoing connections through whichever one a
+// "route:name" blacklist rule picks for a given request.
+func (r *Redirector) InitUpstreams() error {
+	if r.Upstreams == "" {
 		return nil
 	}
-	if err != io.EOF {
+
+	ups, err := upstream.Load(r.Upstreams)
+	if err != nil {
 		log.Println(err)
 		return err
 	}
+	r.Pool = upstream.NewPool(ups)
+	go r.Pool.HealthCheck(r.CheckURL, r.CheckInterval, nil)
+
+	r.Proxy.Tr.Proxy = func(req *http.Request) (*url.URL, error) {
+		name, ok := r.Rules.RouteFor(hostOnly(req.URL.Host), req.URL.String())
+		if !ok {
+			return http.ProxyFromEnvironment(req)
+		}
+		u, ok := r.Pool.Get(name)
+		if !ok {
+			return nil, fmt.Errorf("upstream: %q unavailable", name)
+		}
+		return u.URL, nil
+	}
+
+	r.Proxy.ConnectDialWithReq = func(req *http.Request, network, addr string) (net.Conn, error) {
+		name, ok := r.Rules.RouteFor(hostOnly(req.URL.Host), req.URL.String())
+		if !ok {
+			return net.Dial(network, addr)
+		}
+		u, ok := r.Pool.Get(name)
+		if !ok {
+			return nil, fmt.Errorf("upstream: %q unavailable", name)
+		}
+		return r.connectDialVia(u, network, addr)
+	}
 
 	return nil
 }
 
-func (r *Redirector) Redirect(ctx *goproxy.ProxyCtx) {
-	parts := strings.Split(r.WebAddr, ":")
-	if parts[0] == "" {
-		parts[0] = "127.0.0.1"
+// connectDialVia tunnels a CONNECT through u, the upstream a "route:name"
+// rule picked. http/https upstreams are dialed with goproxy's own CONNECT
+// client; socks5 upstreams use golang.org/x/net/proxy. Any other scheme is
+// rejected rather than risk a nil dialer.
+func (r *Redirector) connectDialVia(u *upstream.Upstream, network, addr string) (net.Conn, error) {
+	switch u.URL.Scheme {
+	case "", "http", "https":
+		dial := r.Proxy.NewConnectDialToProxyWithHandler(u.URL.String(), func(connectReq *http.Request) {
+			if user := u.URL.User; user != nil {
+				if pass, ok := user.Password(); ok {
+					auth := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + pass))
+					connectReq.Header.Set("Proxy-Authorization", "Basic "+auth)
+				}
+			}
+		})
+		if dial == nil {
+			return nil, fmt.Errorf("upstream: could not build CONNECT dialer for %q", u.URL)
+		}
+		return dial(network, addr)
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if user := u.URL.User; user != nil {
+			auth = &proxy.Auth{User: user.Username()}
+			auth.Password, _ = user.Password()
+		}
+		dialer, err := proxy.SOCKS5("tcp", u.URL.Host, auth, proxy.Direct)
+		if err != nil {
+			return nil, fmt.Errorf("upstream: socks5 dialer for %q: %w", u.URL, err)
+		}
+		return dialer.Dial(network, addr)
+	default:
+		return nil, fmt.Errorf("upstream: unsupported scheme %q for %q", u.URL.Scheme, u.URL)
 	}
-	ctx.Req.URL.Host = strings.Join(parts, ":")
-	ctx.Req.RequestURI = ctx.Req.URL.Host
-	ctx.Req.URL.Scheme = "http"
 }
 
-func (r *Redirector) IsDenied(host string) bool {
+// BlockResponse builds the synthesized 403 response served in place of a
+// request that IsDenied flagged as d.
+func (r *Redirector) BlockResponse(req *http.Request, d Decision) *http.Response {
+	var buf bytes.Buffer
+	if err := blockpage.Render(&buf, r.TemplateDir, blockpage.Page{
+		URL:         req.URL.String(),
+		Rule:        d.Rule,
+		Reason:      d.Reason,
+		MinutesLeft: d.MinutesLeft,
+	}); err != nil {
+		log.Println(err)
+	}
+
+	return goproxy.NewResponse(req, goproxy.ContentTypeHtml, http.StatusForbidden, buf.String())
+}
 
-	found := false
-	for i := 0; i < len(r.Hosts); i++ {
-		found = found || strings.Contains(host, r.Hosts[i])
+// hostOnly strips a ":port" suffix from a request authority, e.g. the
+// "blocked.example:443" goproxy hands CONNECT handlers, so suffix-trie
+// matching sees the bare hostname rather than a label poisoned by the
+// port.
+func hostOnly(authority string) string {
+	host, _, err := net.SplitHostPort(authority)
+	if err != nil {
+		return authority
 	}
+	return host
+}
+
+// IsDenied evaluates authority (a request's Host, which for a CONNECT is
+// "host:port") and, when known, the full request url (for |prefix and
+// /regex/ rules) against the ruleset and the current blocking state.
+func (r *Redirector) IsDenied(authority, url string) Decision {
+
+	found, rule := r.Rules.IsDenied(hostOnly(authority), url)
 
 	deny := r.Blockmode
+	reason := ""
+	if deny {
+		reason = "blockmode"
+	}
 
+	minutesLeft := 0
 	if len(r.Hours) > 0 {
-		h, _, _ := time.Now().Clock()
+		h, m, _ := time.Now().Clock()
 		for i := 0; i < len(r.Hours); i++ {
 			if r.Hours[i] != "" {
 				hours := strings.Split(r.Hours[i], "-")
@@ -164,70 +453,23 @@ func (r *Redirector) IsDenied(host string) bool {
 
 				if h >= start && h <= stop {
 					deny = true
+					reason = "hours"
+					minutesLeft = (stop+1-h)*60 - m
 				}
 			}
 		}
 	}
 
-	deny = deny || r.Clocking
-
-	return found && deny
-}
-
-func (r *Redirector) InitOrgReader() error {
-	go func() {
-		for {
-			r.IsClocking()
-			log.Printf("Clocking %t", r.Clocking)
-			time.Sleep(10 * time.Second)
-		}
-	}()
-
-	return nil
-}
-
-func (r *Redirector) IsClocking() {
-	r.Clocking = false
-	filepath.Walk(r.OrgDir, r.Visit)
-}
-
-func (r *Redirector) Visit(path string, info os.FileInfo, e error) error {
-	if info.IsDir() {
-		return nil
-	}
-
-	isOrg, _ := regexp.MatchString("\\.org", path)
-	if !isOrg {
-		return nil
+	if r.Focus.IsFocused() {
+		deny = true
+		reason = "clocking"
+		minutesLeft = 0
 	}
 
-	f, err := os.Open(path)
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-	defer f.Close()
-	reader := bufio.NewReaderSize(f, 16*1024)
-	line, isPrefix, err := reader.ReadLine()
-	for err == nil && !isPrefix {
-		s := string(line)
-
-		isClock, _ := regexp.MatchString(".*CLOCK:.*", s)
-		isEnded, _ := regexp.MatchString(".*CLOCK:.*--.*=>.*", s)
-
-		if isClock && !isEnded {
-			r.Clocking = true
-		}
-
-		line, isPrefix, err = reader.ReadLine()
+	return Decision{
+		Denied:      found && deny,
+		Rule:        rule,
+		Reason:      reason,
+		MinutesLeft: minutesLeft,
 	}
-	if isPrefix {
-		log.Println("buffer size to small")
-		return nil
-	}
-	if err != io.EOF {
-		log.Println(err)
-		return err
-	}
-	return nil
 }
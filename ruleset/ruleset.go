@@ -0,0 +1,358 @@
+// Package ruleset implements an AutoProxy/GFWList-flavored rule matcher for
+// the redirector blacklist: domain-suffix rules compiled into a trie,
+// URL-prefix and regex rules kept as flat lists, and "@@" whitelist
+// exceptions checked ahead of everything else.
+package ruleset
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// node is one label of the reversed-domain suffix trie, e.g. the rule
+// "||ok.ru" is stored as root -> "ru" -> "ok" with the "ok" node marked
+// terminal.
+type node struct {
+	children map[string]*node
+	terminal bool
+	rule     string
+}
+
+func newNode() *node {
+	return &node{children: make(map[string]*node)}
+}
+
+func (n *node) add(labels []string, rule string) {
+	cur := n
+	for i := len(labels) - 1; i >= 0; i-- {
+		label := labels[i]
+		next, ok := cur.children[label]
+		if !ok {
+			next = newNode()
+			cur.children[label] = next
+		}
+		cur = next
+	}
+	cur.terminal = true
+	cur.rule = rule
+}
+
+// match walks the trie label by label from the TLD down and returns the
+// rule of the longest terminal node seen along the way, so "sub.ok.ru"
+// matches a "||ok.ru" rule but "book.ru" does not.
+func (n *node) match(labels []string) (bool, string) {
+	cur := n
+	matched := false
+	rule := ""
+	for i := len(labels) - 1; i >= 0; i-- {
+		next, ok := cur.children[labels[i]]
+		if !ok {
+			break
+		}
+		cur = next
+		if cur.terminal {
+			matched = true
+			rule = cur.rule
+		}
+	}
+	return matched, rule
+}
+
+type matcher struct {
+	suffixes *node
+	prefixes []string
+	regexes  []*regexp.Regexp
+}
+
+func newMatcher() *matcher {
+	return &matcher{suffixes: newNode()}
+}
+
+func (m *matcher) addSuffix(domain, rule string) {
+	m.suffixes.add(strings.Split(domain, "."), rule)
+}
+
+func (m *matcher) addPrefix(prefix, rule string) {
+	m.prefixes = append(m.prefixes, prefix)
+	_ = rule
+}
+
+func (m *matcher) addRegex(re *regexp.Regexp, rule string) {
+	m.regexes = append(m.regexes, re)
+	_ = rule
+}
+
+// match reports whether host/url is covered by this matcher and, if so,
+// which rule matched.
+func (m *matcher) match(host, url string) (bool, string) {
+	if ok, rule := m.suffixes.match(strings.Split(host, ".")); ok {
+		return true, rule
+	}
+	for _, p := range m.prefixes {
+		if strings.HasPrefix(url, p) {
+			return true, "|" + p
+		}
+	}
+	for _, re := range m.regexes {
+		if re.MatchString(url) || re.MatchString(host) {
+			return true, "/" + re.String() + "/"
+		}
+	}
+	return false, ""
+}
+
+// routeRule is one "route:name <pattern>" line: matches that pick an
+// upstream by name instead of denying or allowing anything.
+type routeRule struct {
+	name    string
+	matcher *matcher
+}
+
+// RuleSet is a compiled blacklist: a deny matcher and a whitelist matcher,
+// checked whitelist-first so "@@" exceptions always win, plus a routing
+// table of "route:name" rules. It is safe for concurrent use and can be
+// swapped wholesale by Refresh.
+type RuleSet struct {
+	mu       sync.RWMutex
+	deny     *matcher
+	allow    *matcher
+	routes   []routeRule
+	source   string
+	url      string
+	interval time.Duration
+	stopCh   chan struct{}
+}
+
+// New returns an empty RuleSet.
+func New() *RuleSet {
+	return &RuleSet{
+		deny:  newMatcher(),
+		allow: newMatcher(),
+	}
+}
+
+// IsDenied reports whether host (and, for prefix/regex rules, the full
+// url) is blocked, and which rule matched.
+func (rs *RuleSet) IsDenied(host, url string) (bool, string) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	if ok, rule := rs.allow.match(host, url); ok {
+		return false, rule
+	}
+	return rs.deny.match(host, url)
+}
+
+// RouteFor reports the name of the upstream a "route:name" rule says
+// host/url should be forwarded through, if any.
+func (rs *RuleSet) RouteFor(host, url string) (string, bool) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	for _, r := range rs.routes {
+		if ok, _ := r.matcher.match(host, url); ok {
+			return r.name, true
+		}
+	}
+	return "", false
+}
+
+// Load parses path and replaces the current rules.
+func (rs *RuleSet) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	deny, allow, routes, err := parse(f)
+	if err != nil {
+		return err
+	}
+
+	rs.mu.Lock()
+	rs.deny = deny
+	rs.allow = allow
+	rs.routes = routes
+	rs.source = path
+	rs.mu.Unlock()
+
+	return nil
+}
+
+// LoadRemote fetches url once and replaces the current rules, decoding
+// the body as base64 first if it doesn't already look like rule text.
+func (rs *RuleSet) LoadRemote(url string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	deny, allow, routes, err := parse(strings.NewReader(decodeIfNeeded(string(body))))
+	if err != nil {
+		return err
+	}
+
+	rs.mu.Lock()
+	rs.deny = deny
+	rs.allow = allow
+	rs.routes = routes
+	rs.url = url
+	rs.mu.Unlock()
+
+	return nil
+}
+
+// Refresh starts a goroutine that re-fetches url every interval,
+// replacing the rules each time. It stops when Stop is called.
+func (rs *RuleSet) Refresh(url string, interval time.Duration) {
+	rs.mu.Lock()
+	rs.url = url
+	rs.interval = interval
+	rs.stopCh = make(chan struct{})
+	stop := rs.stopCh
+	rs.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := rs.LoadRemote(url); err != nil {
+					fmt.Fprintf(os.Stderr, "ruleset: refresh %s: %v\n", url, err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends a running Refresh goroutine, if any.
+func (rs *RuleSet) Stop() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.stopCh != nil {
+		close(rs.stopCh)
+		rs.stopCh = nil
+	}
+}
+
+// decodeIfNeeded base64-decodes body, which is how GFWList-style remote
+// lists are usually published, falling back to the raw body when it
+// doesn't actually decode to plain rule text. The base64 alphabet
+// includes nearly every character a rule file would too (notably "/"),
+// so unlike the decoding itself, a character blacklist can't tell the two
+// apart.
+func decodeIfNeeded(body string) string {
+	compact := strings.Join(strings.Fields(body), "")
+	decoded, err := base64.StdEncoding.DecodeString(compact)
+	if err != nil || !looksLikeRuleText(string(decoded)) {
+		return body
+	}
+	return string(decoded)
+}
+
+// looksLikeRuleText reports whether s is printable, line-oriented text, as
+// a base64-decoded body must be before decodeIfNeeded trusts it over the
+// raw response.
+func looksLikeRuleText(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for _, r := range s {
+		switch {
+		case r == '\n' || r == '\r' || r == '\t':
+		case r < 0x20 || r == 0x7f:
+			return false
+		}
+	}
+	return true
+}
+
+// addPattern adds one pattern (the part of a rule line after any "@@" or
+// "route:name" prefix has been stripped) to m under rule, a ||suffix,
+// |prefix, or /regex/ pattern, falling back to a suffix match for a bare
+// hostname.
+func addPattern(m *matcher, pattern, rule string) error {
+	switch {
+	case strings.HasPrefix(pattern, "||"):
+		m.addSuffix(strings.TrimSpace(strings.TrimPrefix(pattern, "||")), rule)
+	case strings.HasPrefix(pattern, "|"):
+		m.addPrefix(strings.TrimSpace(strings.TrimPrefix(pattern, "|")), rule)
+	case strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") && len(pattern) > 1:
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return fmt.Errorf("ruleset: bad regexp %q: %w", pattern, err)
+		}
+		m.addRegex(re, rule)
+	default:
+		m.addSuffix(pattern, rule)
+	}
+	return nil
+}
+
+func parse(r io.Reader) (deny *matcher, allow *matcher, routes []routeRule, err error) {
+	deny = newMatcher()
+	allow = newMatcher()
+	byName := make(map[string]*matcher)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 16*1024), 16*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "!") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "route:") {
+			name, pattern, ok := strings.Cut(strings.TrimPrefix(line, "route:"), " ")
+			if !ok {
+				continue
+			}
+			pattern = strings.TrimSpace(pattern)
+
+			m, ok := byName[name]
+			if !ok {
+				m = newMatcher()
+				byName[name] = m
+				routes = append(routes, routeRule{name: name, matcher: m})
+			}
+			if err := addPattern(m, pattern, line); err != nil {
+				return nil, nil, nil, err
+			}
+			continue
+		}
+
+		target := deny
+		rule := line
+		if strings.HasPrefix(line, "@@") {
+			target = allow
+			line = strings.TrimPrefix(line, "@@")
+		}
+
+		if err := addPattern(target, line, rule); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return deny, allow, routes, nil
+}
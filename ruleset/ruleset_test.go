@@ -0,0 +1,151 @@
+package ruleset
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func serveBody(body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(body))
+	}))
+}
+
+func writeRules(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "blacklist")
+	if err := os.WriteFile(path, []byte(body), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestIsDeniedSuffixDoesNotMatchUnrelatedDomain(t *testing.T) {
+	rs := New()
+	if err := rs.Load(writeRules(t, "||ok.ru\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _ := rs.IsDenied("sub.ok.ru", "sub.ok.ru"); !found {
+		t.Error("sub.ok.ru: want denied, got allowed")
+	}
+	if found, _ := rs.IsDenied("book.ru", "book.ru"); found {
+		t.Error("book.ru: want allowed, got denied (suffix rule matched on substring, not label)")
+	}
+}
+
+func TestIsDeniedPrefix(t *testing.T) {
+	rs := New()
+	if err := rs.Load(writeRules(t, "|http://example.com/ads\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _ := rs.IsDenied("example.com", "http://example.com/ads/banner.js"); !found {
+		t.Error("matching prefix: want denied, got allowed")
+	}
+	if found, _ := rs.IsDenied("example.com", "http://example.com/other"); found {
+		t.Error("non-matching prefix: want allowed, got denied")
+	}
+}
+
+func TestIsDeniedRegex(t *testing.T) {
+	rs := New()
+	if err := rs.Load(writeRules(t, "/ads?\\d+\\.example\\.com/\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _ := rs.IsDenied("ads7.example.com", "ads7.example.com"); !found {
+		t.Error("regex match on host: want denied, got allowed")
+	}
+	if found, _ := rs.IsDenied("example.com", "example.com"); found {
+		t.Error("regex non-match: want allowed, got denied")
+	}
+}
+
+func TestIsDeniedWhitelistWinsOverDeny(t *testing.T) {
+	rs := New()
+	if err := rs.Load(writeRules(t, "||ads.example.com\n@@||cdn.ads.example.com\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _ := rs.IsDenied("cdn.ads.example.com", "cdn.ads.example.com"); found {
+		t.Error("whitelisted subdomain: want allowed, got denied")
+	}
+	if found, _ := rs.IsDenied("ads.example.com", "ads.example.com"); !found {
+		t.Error("non-whitelisted domain: want denied, got allowed")
+	}
+}
+
+func TestRouteForMatchesNamedRoute(t *testing.T) {
+	rs := New()
+	if err := rs.Load(writeRules(t, "route:corp || intranet.example.com\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	name, ok := rs.RouteFor("intranet.example.com", "intranet.example.com")
+	if !ok || name != "corp" {
+		t.Errorf("RouteFor(intranet.example.com) = %q, %v; want \"corp\", true", name, ok)
+	}
+
+	if _, ok := rs.RouteFor("elsewhere.example.com", "elsewhere.example.com"); ok {
+		t.Error("RouteFor(elsewhere.example.com): want no route, got one")
+	}
+}
+
+func TestCommentsAndBlankLinesIgnored(t *testing.T) {
+	rs := New()
+	if err := rs.Load(writeRules(t, "! a comment\n\n||ok.ru\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _ := rs.IsDenied("ok.ru", "ok.ru"); !found {
+		t.Error("want denied, got allowed")
+	}
+}
+
+func TestLoadRemoteDecodesBase64Body(t *testing.T) {
+	// "||blocked.example\n" base64-encoded, as a real GFWList mirror would
+	// serve it.
+	const encoded = "fHxibG9ja2VkLmV4YW1wbGUK"
+
+	srv := serveBody(encoded)
+	defer srv.Close()
+
+	rs := New()
+	if err := rs.LoadRemote(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _ := rs.IsDenied("blocked.example", "blocked.example"); !found {
+		t.Error("want denied after decoding base64 remote list, got allowed")
+	}
+}
+
+func TestLoadRemotePassesThroughPlainBody(t *testing.T) {
+	srv := serveBody("||blocked.example\n")
+	defer srv.Close()
+
+	rs := New()
+	if err := rs.LoadRemote(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _ := rs.IsDenied("blocked.example", "blocked.example"); !found {
+		t.Error("want denied with a plain-text remote list, got allowed")
+	}
+}
+
+func TestDecodeIfNeeded(t *testing.T) {
+	plain := "||ok.ru\n!comment\n@@||safe.ru\n/ads\\d+/\n"
+	if got := decodeIfNeeded(plain); got != plain {
+		t.Errorf("plain body was altered: got %q, want %q", got, plain)
+	}
+
+	encoded := "fHxvay5ydQo=" // "||ok.ru\n"
+	if got := decodeIfNeeded(encoded); got != "||ok.ru\n" {
+		t.Errorf("decodeIfNeeded(%q) = %q, want %q", encoded, got, "||ok.ru\n")
+	}
+}
@@ -0,0 +1,125 @@
+package stats
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var hostsBucket = []byte("hosts")
+
+// HostStats are the running totals kept for one host.
+type HostStats struct {
+	Allowed  int64     `json:"allowed"`
+	Blocked  int64     `json:"blocked"`
+	Bytes    int64     `json:"bytes"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Counters keeps per-host HostStats in memory, periodically persisted to
+// a bbolt file by PersistLoop.
+type Counters struct {
+	mu    sync.RWMutex
+	hosts map[string]*HostStats
+}
+
+// NewCounters returns an empty set of counters.
+func NewCounters() *Counters {
+	return &Counters{hosts: make(map[string]*HostStats)}
+}
+
+// Record folds one request's outcome into host's running totals.
+func (c *Counters) Record(host string, allowed bool, bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, ok := c.hosts[host]
+	if !ok {
+		h = &HostStats{}
+		c.hosts[host] = h
+	}
+	if allowed {
+		h.Allowed++
+	} else {
+		h.Blocked++
+	}
+	h.Bytes += bytes
+	h.LastSeen = time.Now()
+}
+
+// Snapshot returns a copy of the current per-host totals.
+func (c *Counters) Snapshot() map[string]HostStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	snap := make(map[string]HostStats, len(c.hosts))
+	for host, h := range c.hosts {
+		snap[host] = *h
+	}
+	return snap
+}
+
+// LoadFrom populates the counters from a previously persisted bbolt file.
+func (c *Counters) LoadFrom(db *bolt.DB) error {
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(hostsBucket)
+		if b == nil {
+			return nil
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return b.ForEach(func(k, v []byte) error {
+			var h HostStats
+			if err := json.Unmarshal(v, &h); err != nil {
+				return err
+			}
+			c.hosts[string(k)] = &h
+			return nil
+		})
+	})
+}
+
+// persist writes the current snapshot to db, one JSON value per host.
+func (c *Counters) persist(db *bolt.DB) error {
+	snap := c.Snapshot()
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(hostsBucket)
+		if err != nil {
+			return err
+		}
+		for host, h := range snap {
+			v, err := json.Marshal(h)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(host), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PersistLoop persists the counters to db every interval until stop is
+// closed.
+func (c *Counters) PersistLoop(db *bolt.DB, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.persist(db); err != nil {
+				log.Println(err)
+			}
+		case <-stop:
+			if err := c.persist(db); err != nil {
+				log.Println(err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// StatsHandler serves the per-host counters as JSON, for /api/stats.
+func StatsHandler(c *Counters) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.Snapshot())
+	}
+}
+
+// HistoryHandler serves the recorded entries for ?host=..., for
+// /api/history.
+func HistoryHandler(h *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.ForHost(req.URL.Query().Get("host")))
+	}
+}
+
+// BlockedHandler serves denied entries since ?since=<RFC3339>, defaulting
+// to the last 24 hours, for /api/blocked.
+func BlockedHandler(h *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		since := time.Now().Add(-24 * time.Hour)
+		if s := req.URL.Query().Get("since"); s != "" {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				since = t
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.Blocked(since))
+	}
+}
+
+// ReloadHandler calls reload and reports its outcome, for
+// /api/rules/reload.
+func ReloadHandler(reload func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if err := reload(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("reloaded"))
+	}
+}
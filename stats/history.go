@@ -0,0 +1,59 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// History is a bounded, in-memory log of recent Entries, queryable by
+// host (for /api/history) or by time (for /api/blocked).
+type History struct {
+	mu      sync.RWMutex
+	entries []Entry
+	max     int
+}
+
+// NewHistory returns a History that keeps at most max entries, discarding
+// the oldest ones once full.
+func NewHistory(max int) *History {
+	return &History{max: max}
+}
+
+// Add appends e, trimming the oldest entry if the history is full.
+func (h *History) Add(e Entry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.entries = append(h.entries, e)
+	if overflow := len(h.entries) - h.max; overflow > 0 {
+		h.entries = h.entries[overflow:]
+	}
+}
+
+// ForHost returns every kept entry for host, oldest first.
+func (h *History) ForHost(host string) []Entry {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var out []Entry
+	for _, e := range h.entries {
+		if e.Host == host {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Blocked returns every kept denied entry at or after since, oldest first.
+func (h *History) Blocked(since time.Time) []Entry {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var out []Entry
+	for _, e := range h.entries {
+		if !e.Allowed && !e.Time.Before(since) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+// Package stats keeps an access log and per-host counters for proxied
+// requests, persists the counters to a bbolt file, and serves them as
+// JSON on the web port.
+package stats
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is one proxied request, as written to the access log and folded
+// into the in-memory counters and history.
+type Entry struct {
+	Time    time.Time
+	Host    string
+	Method  string
+	URL     string
+	Status  int
+	Bytes   int64
+	Allowed bool
+	Rule    string
+}
+
+// AccessLogger appends Entries to a file in one of the supported formats,
+// and can reopen that file in place (for SIGHUP-driven rotation).
+type AccessLogger struct {
+	mu     sync.Mutex
+	path   string
+	format string
+	file   *os.File
+}
+
+// NewAccessLogger opens (creating/appending) path and returns a logger
+// that formats entries as format: "combined", "json", or "tsv".
+func NewAccessLogger(path, format string) (*AccessLogger, error) {
+	l := &AccessLogger{path: path, format: format}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *AccessLogger) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	l.file = f
+	return nil
+}
+
+// Reopen closes and reopens the log file, for rotation: the rotating
+// process renames the old file out of the way and sends SIGHUP, and this
+// call makes the logger start writing to a fresh file at the same path.
+func (l *AccessLogger) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file != nil {
+		l.file.Close()
+	}
+	return l.open()
+}
+
+// Log formats e and appends it to the log file.
+func (l *AccessLogger) Log(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var line string
+	switch l.format {
+	case "json":
+		line = formatJSON(e)
+	case "tsv":
+		line = formatTSV(e)
+	default:
+		line = formatCombined(e)
+	}
+
+	_, err := fmt.Fprintln(l.file, line)
+	return err
+}
+
+func formatCombined(e Entry) string {
+	action := "ALLOW"
+	if !e.Allowed {
+		action = "DENY"
+	}
+	return fmt.Sprintf(`%s - - [%s] "%s %s" %d %d %s`,
+		e.Host, e.Time.Format("02/Jan/2006:15:04:05 -0700"), e.Method, e.URL, e.Status, e.Bytes, action)
+}
+
+func formatJSON(e Entry) string {
+	return fmt.Sprintf(
+		`{"time":%q,"host":%q,"method":%q,"url":%q,"status":%d,"bytes":%d,"allowed":%t,"rule":%q}`,
+		e.Time.Format(time.RFC3339), e.Host, e.Method, e.URL, e.Status, e.Bytes, e.Allowed, e.Rule)
+}
+
+func formatTSV(e Entry) string {
+	return fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%d\t%t\t%s",
+		e.Time.Format(time.RFC3339), e.Host, e.Method, e.URL, e.Status, e.Bytes, e.Allowed, e.Rule)
+}